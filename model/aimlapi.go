@@ -18,11 +18,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/casibase/casibase/proxy"
 	aimlapi "github.com/D1m7asis/casibase-aimlapi-go"
+	"github.com/casibase/casibase/proxy"
 )
 
 // AIMLAPIModelProvider implements Casibase provider for AI/ML API.
@@ -33,9 +35,88 @@ type AIMLAPIModelProvider struct {
 	siteUrl     string
 	temperature *float32
 	topP        *float32
+
+	pricingSource       PricingSource
+	extraPricingSources []PricingSource
+	currencyConverter   *CurrencyConverter
+	currency            string
+
+	maxPricePerCapability map[string]float64
+	maxBudgetPerRequest   float64
+
+	trimStrategy    TrimStrategy
+	summarizerModel string
+
+	registry *ModelRegistry
+}
+
+// AIMLAPIOption configures optional AIMLAPIModelProvider behavior at
+// construction time, e.g. budget guardrails.
+type AIMLAPIOption func(*AIMLAPIModelProvider)
+
+// WithMaxPricePerCapability caps spend per model id or capability bucket
+// ("chat", "vision", "reasoning"), in USD. A request whose estimated cost
+// exceeds the applicable cap is rejected before the upstream call is made.
+func WithMaxPricePerCapability(caps map[string]float64) AIMLAPIOption {
+	return func(p *AIMLAPIModelProvider) {
+		for k, v := range caps {
+			p.maxPricePerCapability[k] = v
+		}
+	}
+}
+
+// WithMaxBudgetPerRequest caps the total spend of a single QueryText call in
+// USD, regardless of model. It combines with WithMaxPricePerCapability by
+// taking whichever cap is tighter.
+func WithMaxBudgetPerRequest(maxBudgetUSD float64) AIMLAPIOption {
+	return func(p *AIMLAPIModelProvider) {
+		p.maxBudgetPerRequest = maxBudgetUSD
+	}
+}
+
+// WithFilePricingSource layers a JSON price table on disk
+// ({"openai/gpt-4o": [0.005, 0.015], ...}) on top of the registry-backed
+// default, consulted first so a price correction file can override the
+// embedded manifest without recompiling it. The registry is still consulted
+// for any model the file doesn't list.
+func WithFilePricingSource(path string) AIMLAPIOption {
+	return func(p *AIMLAPIModelProvider) {
+		p.extraPricingSources = append(p.extraPricingSources, newFilePricingSource(path))
+	}
+}
+
+// WithRemotePricingSource layers a JSON price table fetched from an HTTPS
+// endpoint on top of the registry-backed default, refreshed every ttl (plus
+// jitter) in the background; the last good snapshot keeps serving if a
+// refresh fails. The registry is still consulted for any model the endpoint
+// doesn't list.
+func WithRemotePricingSource(url string, ttl time.Duration) AIMLAPIOption {
+	return func(p *AIMLAPIModelProvider) {
+		source := newRemotePricingSource(url, proxy.ProxyHttpClient, ttl)
+		source.Start()
+		p.extraPricingSources = append(p.extraPricingSources, source)
+	}
+}
+
+// WithTrimStrategy picks how buildMessages makes room for history that no
+// longer fits the model's context window. Defaults to TrimHeadDrop.
+func WithTrimStrategy(strategy TrimStrategy) AIMLAPIOption {
+	return func(p *AIMLAPIModelProvider) {
+		p.trimStrategy = strategy
+	}
+}
+
+// WithSummarizerModel overrides the cheap model TrimSummarizeOldest calls to
+// compress dropped history turns. Defaults to defaultSummarizerModel.
+func WithSummarizerModel(model string) AIMLAPIOption {
+	return func(p *AIMLAPIModelProvider) {
+		p.summarizerModel = model
+	}
 }
 
-func NewAIMLAPIModelProvider(subType string, secretKey string, temperature float32, topP float32) (*AIMLAPIModelProvider, error) {
+func NewAIMLAPIModelProvider(subType string, secretKey string, temperature float32, topP float32, options ...AIMLAPIOption) (*AIMLAPIModelProvider, error) {
+	envMaxPricePerCapability, envMaxBudgetPerRequest := loadBudgetFromEnv()
+
 	p := &AIMLAPIModelProvider{
 		subType:     subType,
 		secretKey:   secretKey,
@@ -43,10 +124,54 @@ func NewAIMLAPIModelProvider(subType string, secretKey string, temperature float
 		siteUrl:     "https://casibase.org",
 		temperature: &temperature,
 		topP:        &topP,
+
+		currencyConverter: NewCurrencyConverter(nil),
+		currency:          "USD",
+
+		maxPricePerCapability: envMaxPricePerCapability,
+		maxBudgetPerRequest:   envMaxBudgetPerRequest,
+
+		trimStrategy:    TrimHeadDrop,
+		summarizerModel: defaultSummarizerModel,
+
+		registry: defaultModelRegistry,
 	}
+
+	for _, option := range options {
+		option(p)
+	}
+
+	// Built after options run so a WithModelRegistryOverrides call is
+	// reflected in the prices this provider bills, not just ListModels. Any
+	// WithFilePricingSource/WithRemotePricingSource sources are tried first,
+	// falling back to the registry so pricing degrades gracefully rather
+	// than failing closed.
+	static := &staticPricingSource{registry: p.registry}
+	if len(p.extraPricingSources) > 0 {
+		p.pricingSource = &chainedPricingSource{sources: append(p.extraPricingSources, static)}
+	} else {
+		p.pricingSource = static
+	}
+
 	return p, nil
 }
 
+// SetPricingSource overrides the provider's PricingSource outright, bypassing
+// the registry/file/remote chain WithFilePricingSource and
+// WithRemotePricingSource build. Prefer those options unless the caller needs
+// a wholly custom PricingSource, in which case it should fall back to the
+// registry itself so pricing degrades gracefully rather than failing closed.
+func (p *AIMLAPIModelProvider) SetPricingSource(source PricingSource) {
+	p.pricingSource = source
+}
+
+// SetCurrency selects the currency ModelResult.TotalPrice is reported in.
+// The converter must have a rate configured for it (see CurrencyConverter).
+func (p *AIMLAPIModelProvider) SetCurrency(currency string, converter *CurrencyConverter) {
+	p.currency = currency
+	p.currencyConverter = converter
+}
+
 func (p *AIMLAPIModelProvider) GetPricing() string {
 	// Pricing depends on the selected model and may change over time.
 	// Please refer to AIMLAPI official pricing page.
@@ -59,48 +184,31 @@ Notes:
 `
 }
 
-// calculatePrice assigns token usage cost if known; otherwise defaults to 0 USD.
-func (p *AIMLAPIModelProvider) calculatePrice(modelResult *ModelResult) error {
-	var inputPricePerThousandTokens, outputPricePerThousandTokens float64
-
-	// Example price table (incomplete, extend as needed).
-	priceTable := map[string][]float64{
-		// OpenAI
-		"openai/gpt-4o":          {0.005, 0.015},
-		"gpt-4o-2024-05-13":      {0.005, 0.015},
-		"gpt-4o-mini":            {0.003, 0.006},
-		"gpt-3.5-turbo":          {0.001, 0.002},
-
-		// Anthropic
-		"claude-3-5-sonnet-20240620": {0.003, 0.015},
-		"claude-3-haiku-20240307":    {0.0008, 0.0024},
-
-		// Google
-		"google/gemini-2.5-pro":  {0.0025, 0.0075},
-		"google/gemma-3-4b-it":   {0.0004, 0.0008},
+// calculatePrice assigns token usage cost from the configured PricingSource,
+// converted into the provider's configured currency. model is the resolved
+// model name (p.subType with the "" default already substituted), since no
+// manifest entry is ever keyed by the empty string. An unknown model returns
+// ErrUnknownModelPricing rather than silently billing 0.
+func (p *AIMLAPIModelProvider) calculatePrice(model string, modelResult *ModelResult) error {
+	priceEntry, err := p.pricingSource.GetPrice(model)
+	if err != nil {
+		return err
+	}
 
-		// Meta
-		"meta-llama/Meta-Llama-3.1-8B-Instruct-Turbo": {0.0002, 0.0006},
-		"meta-llama/Llama-3-8b-chat-hf":               {0.0002, 0.0006},
+	inputTokens := big.NewRat(int64(modelResult.PromptTokenCount), 1000)
+	outputTokens := big.NewRat(int64(modelResult.ResponseTokenCount), 1000)
 
-		// DeepSeek
-		"deepseek-chat":     {0.0006, 0.0012},
-		"deepseek-reasoner": {0.0015, 0.0030},
-	}
+	inputPrice := new(big.Rat).Mul(inputTokens, priceEntry.InputPerThousand)
+	outputPrice := new(big.Rat).Mul(outputTokens, priceEntry.OutputPerThousand)
+	totalPriceUSD := new(big.Rat).Add(inputPrice, outputPrice)
 
-	if priceItem, ok := priceTable[p.subType]; ok {
-		inputPricePerThousandTokens = priceItem[0]
-		outputPricePerThousandTokens = priceItem[1]
-	} else {
-		// Unknown model â†’ fallback: free (0 USD).
-		inputPricePerThousandTokens = 0
-		outputPricePerThousandTokens = 0
+	totalPrice, err := p.currencyConverter.Convert(totalPriceUSD, p.currency)
+	if err != nil {
+		return err
 	}
 
-	inputPrice := getPrice(modelResult.PromptTokenCount, inputPricePerThousandTokens)
-	outputPrice := getPrice(modelResult.ResponseTokenCount, outputPricePerThousandTokens)
-	modelResult.TotalPrice = AddPrices(inputPrice, outputPrice)
-	modelResult.Currency = "USD"
+	modelResult.TotalPrice, _ = totalPrice.Float64()
+	modelResult.Currency = p.currency
 	return nil
 }
 
@@ -114,26 +222,49 @@ func (p *AIMLAPIModelProvider) getClient() *aimlapi.Client {
 }
 
 func (p *AIMLAPIModelProvider) QueryText(question string, writer io.Writer, history []*RawMessage, prompt string, knowledgeMessages []*RawMessage, agentInfo *AgentInfo) (*ModelResult, error) {
+	return p.queryText(question, nil, writer, history, prompt, knowledgeMessages, agentInfo)
+}
+
+// QueryTextWithTools is QueryText plus function/tool calling: tools are
+// advertised to the model, and any tool calls it emits are streamed as
+// `event: tool_call` chunks and returned assembled in ModelResult.ToolCalls
+// so an AgentInfo-driven agent can execute them and continue the turn.
+func (p *AIMLAPIModelProvider) QueryTextWithTools(question string, tools []ToolSpec, writer io.Writer, history []*RawMessage, prompt string, knowledgeMessages []*RawMessage, agentInfo *AgentInfo) (*ModelResult, error) {
+	return p.queryText(question, tools, writer, history, prompt, knowledgeMessages, agentInfo)
+}
+
+func (p *AIMLAPIModelProvider) queryText(question string, tools []ToolSpec, writer io.Writer, history []*RawMessage, prompt string, knowledgeMessages []*RawMessage, agentInfo *AgentInfo) (*ModelResult, error) {
 	client := p.getClient()
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	flusher, ok := writer.(http.Flusher)
 	if !ok {
 		return nil, fmt.Errorf("writer does not implement http.Flusher")
 	}
 
+	// writer is the http.ResponseWriter streaming the SSE response; if it
+	// also implements http.CloseNotifier, a client disconnecting mid-stream
+	// cancels ctx so the upstream call is torn down instead of running to
+	// completion for nobody.
+	if closeNotifier, ok := writer.(http.CloseNotifier); ok { //nolint:staticcheck // no *http.Request is threaded through to use Request.Context() instead
+		go func() {
+			select {
+			case <-closeNotifier.CloseNotify():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
 	model := p.subType
 	if model == "" {
 		// Default AIMLAPI model
 		model = "openai/gpt-4o"
 	}
 
-	tokenCount, err := GetTokenSize(model, question)
-	if err != nil {
-		return nil, err
-	}
-
-	contextLength := getContextLength(p.subType)
+	contextLength := p.registry.ContextLength(model)
 
 	if strings.HasPrefix(question, "$CasibaseDryRun$") {
 		modelResult, err := getDefaultModelResult(model, question, "")
@@ -147,34 +278,45 @@ func (p *AIMLAPIModelProvider) QueryText(question string, writer io.Writer, hist
 		}
 	}
 
-	maxTokens := contextLength - tokenCount
+	messages, err := p.buildMessages(model, prompt, knowledgeMessages, history, question, contextLength)
+	if err != nil {
+		return nil, err
+	}
+
+	maxTokens := contextLength - messages.PromptTokenCount
 	if maxTokens < 0 {
-		return nil, fmt.Errorf("Token count [%d] exceeds model [%s] max context [%d]", tokenCount, model, contextLength)
+		return nil, fmt.Errorf("Token count [%d] exceeds model [%s] max context [%d]", messages.PromptTokenCount, model, contextLength)
+	}
+
+	budgetCap := p.effectiveCap(model)
+	if budgetCap > 0 {
+		estimatedCost, err := p.estimateWorstCaseCost(model, messages.PromptTokenCount, maxTokens)
+		if err != nil {
+			return nil, err
+		}
+		if estimatedCost > budgetCap {
+			return nil, &ErrBudgetExceeded{Model: model, Estimated: estimatedCost, Cap: budgetCap}
+		}
 	}
 
 	temperature := p.temperature
 	topP := p.topP
 
-	respStream, err := client.CreateChatCompletionStream(
-		ctx,
-		&aimlapi.ChatCompletionRequest{
-			Model: p.subType,
-			Messages: []aimlapi.ChatCompletionMessage{
-				{
-					Role:    aimlapi.ChatMessageRoleSystem,
-					Content: "You are a helpful assistant.",
-				},
-				{
-					Role:    aimlapi.ChatMessageRoleUser,
-					Content: question,
-				},
-			},
-			Stream:      false,
-			Temperature: temperature,
-			TopP:        topP,
-			MaxTokens:   maxTokens,
-		},
-	)
+	adaptor := getAdaptor(model)
+
+	vendorReq, err := adaptor.ConvertRequest(&AIMLAPIRequest{
+		Model:       model,
+		Messages:    messages.Messages,
+		Temperature: temperature,
+		TopP:        topP,
+		MaxTokens:   maxTokens,
+		Tools:       tools,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	respStream, err := adaptor.DoRequest(ctx, client, vendorReq)
 	if err != nil {
 		return nil, err
 	}
@@ -182,6 +324,8 @@ func (p *AIMLAPIModelProvider) QueryText(question string, writer io.Writer, hist
 
 	responseStringBuilder := strings.Builder{}
 	isLeadingReturn := true
+	toolCalls := newToolCallAccumulator()
+	finishReason := ""
 
 	for {
 		completion, streamErr := respStream.Recv()
@@ -192,29 +336,77 @@ func (p *AIMLAPIModelProvider) QueryText(question string, writer io.Writer, hist
 			return nil, streamErr
 		}
 
-		data := completion.Choices[0].Message.Content
-		if isLeadingReturn && len(data) != 0 {
-			if strings.Count(data, "\n") == len(data) {
-				continue
-			} else {
+		chunk, err := adaptor.ConvertStreamChunk(completion)
+		if err != nil {
+			return nil, err
+		}
+
+		if chunk.FinishReason != "" {
+			finishReason = chunk.FinishReason
+		}
+
+		if chunk.ReasoningText != "" {
+			if _, err = fmt.Fprintf(writer, "event: reasoning\ndata: %s\n\n", chunk.ReasoningText); err != nil {
+				return nil, err
+			}
+			flusher.Flush()
+		}
+
+		if len(chunk.ToolCallDeltas) > 0 {
+			toolCalls.add(chunk.ToolCallDeltas)
+			for _, delta := range chunk.ToolCallDeltas {
+				if _, err = fmt.Fprintf(writer, "event: tool_call\ndata: {\"index\":%d,\"id\":%q,\"name\":%q,\"arguments\":%q}\n\n", delta.Index, delta.Id, delta.Name, delta.ArgumentsFragment); err != nil {
+					return nil, err
+				}
+			}
+			flusher.Flush()
+		}
+
+		data := chunk.Text
+		if data != "" {
+			if isLeadingReturn {
+				if strings.Count(data, "\n") == len(data) {
+					continue
+				}
 				isLeadingReturn = false
 			}
+
+			if _, err = fmt.Fprintf(writer, "event: message\ndata: %s\n\n", data); err != nil {
+				return nil, err
+			}
+
+			_, _ = responseStringBuilder.WriteString(data)
+			flusher.Flush()
 		}
 
-		if _, err = fmt.Fprintf(writer, "event: message\ndata: %s\n\n", data); err != nil {
-			return nil, err
+		if budgetCap > 0 {
+			runningCost, err := p.runningCost(model, messages.PromptTokenCount, responseStringBuilder.String())
+			if err != nil {
+				return nil, err
+			}
+			if runningCost > budgetCap {
+				cancel()
+				return nil, &ErrBudgetExceeded{Model: model, Estimated: runningCost, Cap: budgetCap}
+			}
 		}
+	}
 
-		_, _ = responseStringBuilder.WriteString(data)
-		flusher.Flush()
+	if _, err = fmt.Fprintf(writer, "event: done\ndata: %s\n\n", finishReason); err != nil {
+		return nil, err
 	}
+	flusher.Flush()
 
-	modelResult, err := getDefaultModelResult(p.subType, question, responseStringBuilder.String())
+	modelResult, err := getDefaultModelResult(model, question, responseStringBuilder.String())
 	if err != nil {
 		return nil, err
 	}
 
-	if err := p.calculatePrice(modelResult); err != nil {
+	modelResult.ToolCalls = toolCalls.collect()
+	modelResult.PromptTokenCount = messages.PromptTokenCount
+	// 0 means no cap applied, matching effectiveCap's "0 is unset" convention.
+	modelResult.BudgetCap = budgetCap
+
+	if err := p.calculatePrice(model, modelResult); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,174 @@
+// Copyright 2023 The Casibase Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	aimlapi "github.com/D1m7asis/casibase-aimlapi-go"
+)
+
+// AIMLAPIRequest is the generic, vendor-agnostic request an adaptor converts
+// into whatever shape the upstream vendor expects.
+type AIMLAPIRequest struct {
+	Model       string
+	Messages    []aimlapi.ChatCompletionMessage
+	Temperature *float32
+	TopP        *float32
+	MaxTokens   int
+	Tools       []ToolSpec
+}
+
+// AIMLAPIStreamChunk is the generic result of decoding one chunk of a
+// vendor's streaming response. Text and ReasoningText may both be set on
+// the same chunk; ToolCallDeltas carries tool-call argument
+// fragments that the caller accumulates across chunks by Index.
+type AIMLAPIStreamChunk struct {
+	Text           string
+	ReasoningText  string
+	ToolCallDeltas []ToolCallDelta
+	FinishReason   string
+	Done           bool
+}
+
+// AIMLAPIAdaptor converts between Casibase's generic chat representation and
+// the request/response shape a specific AIMLAPI-hosted vendor expects. Each
+// vendor family (OpenAI-style, Anthropic-style, Google/Gemini-style, Meta
+// Llama, DeepSeek reasoner, ...) gets its own adaptor so that QueryText no
+// longer needs to special-case vendor quirks inline.
+type AIMLAPIAdaptor interface {
+	// ConvertRequest builds the vendor-specific request from the generic one.
+	ConvertRequest(req *AIMLAPIRequest) (*aimlapi.ChatCompletionRequest, error)
+
+	// DoRequest issues the streaming call against the vendor endpoint.
+	DoRequest(ctx context.Context, client *aimlapi.Client, req *aimlapi.ChatCompletionRequest) (*aimlapi.ChatCompletionStream, error)
+
+	// ConvertStreamChunk extracts the answer text, reasoning text, tool-call
+	// fragments and finish reason out of one streamed completion chunk. It
+	// does not report token usage; the caller counts tokens itself.
+	ConvertStreamChunk(completion aimlapi.ChatCompletionStreamResponse) (*AIMLAPIStreamChunk, error)
+}
+
+// getAdaptor picks the AIMLAPIAdaptor responsible for subType based on its
+// vendor prefix, mirroring how one-api dispatches requests to per-vendor
+// adaptors.
+func getAdaptor(subType string) AIMLAPIAdaptor {
+	switch {
+	case strings.HasPrefix(subType, "openai/"), subType == "":
+		return &openAIAdaptor{}
+	case strings.HasPrefix(subType, "claude-"):
+		return &anthropicAdaptor{}
+	case strings.HasPrefix(subType, "google/"):
+		return &googleAdaptor{}
+	case strings.HasPrefix(subType, "meta-llama/"):
+		return &metaLlamaAdaptor{}
+	case strings.HasPrefix(subType, "deepseek-"):
+		return &deepSeekAdaptor{}
+	default:
+		return &openAIAdaptor{}
+	}
+}
+
+// baseOpenAICompatAdaptor implements the parts of AIMLAPIAdaptor that are
+// identical across every vendor AIMLAPI exposes through an OpenAI-compatible
+// `/chat/completions` endpoint. Vendor-specific adaptors embed it and only
+// override ConvertRequest/ConvertStreamChunk where their wire format diverges.
+type baseOpenAICompatAdaptor struct{}
+
+func (a *baseOpenAICompatAdaptor) ConvertRequest(req *AIMLAPIRequest) (*aimlapi.ChatCompletionRequest, error) {
+	return &aimlapi.ChatCompletionRequest{
+		Model:       req.Model,
+		Messages:    req.Messages,
+		Stream:      true,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxTokens,
+		Tools:       toVendorTools(req.Tools),
+	}, nil
+}
+
+func (a *baseOpenAICompatAdaptor) DoRequest(ctx context.Context, client *aimlapi.Client, req *aimlapi.ChatCompletionRequest) (*aimlapi.ChatCompletionStream, error) {
+	return client.CreateChatCompletionStream(ctx, req)
+}
+
+func (a *baseOpenAICompatAdaptor) ConvertStreamChunk(completion aimlapi.ChatCompletionStreamResponse) (*AIMLAPIStreamChunk, error) {
+	if len(completion.Choices) == 0 {
+		return nil, errNoChoices
+	}
+
+	choice := completion.Choices[0]
+	return &AIMLAPIStreamChunk{
+		Text:           choice.Delta.Content,
+		ToolCallDeltas: toToolCallDeltas(choice.Delta.ToolCalls),
+		FinishReason:   choice.FinishReason,
+		Done:           choice.FinishReason != "",
+	}, nil
+}
+
+// openAIAdaptor handles `openai/*` subTypes.
+type openAIAdaptor struct {
+	baseOpenAICompatAdaptor
+}
+
+// anthropicAdaptor handles `claude-*` subTypes.
+type anthropicAdaptor struct {
+	baseOpenAICompatAdaptor
+}
+
+// googleAdaptor handles `google/*` subTypes.
+type googleAdaptor struct {
+	baseOpenAICompatAdaptor
+}
+
+// metaLlamaAdaptor handles `meta-llama/*` subTypes.
+type metaLlamaAdaptor struct {
+	baseOpenAICompatAdaptor
+}
+
+// deepSeekAdaptor handles `deepseek-*` subTypes. DeepSeek's reasoner models
+// emit a separate `reasoning_content` delta alongside `content`; that is
+// surfaced by ConvertStreamChunk so callers can tell reasoning apart from the
+// final answer.
+type deepSeekAdaptor struct {
+	baseOpenAICompatAdaptor
+}
+
+func (a *deepSeekAdaptor) ConvertStreamChunk(completion aimlapi.ChatCompletionStreamResponse) (*AIMLAPIStreamChunk, error) {
+	if len(completion.Choices) == 0 {
+		return nil, errNoChoices
+	}
+
+	choice := completion.Choices[0]
+	return &AIMLAPIStreamChunk{
+		Text:           choice.Delta.Content,
+		ReasoningText:  choice.Delta.ReasoningContent,
+		ToolCallDeltas: toToolCallDeltas(choice.Delta.ToolCalls),
+		FinishReason:   choice.FinishReason,
+		Done:           choice.FinishReason != "",
+	}, nil
+}
+
+// ensure every adaptor satisfies AIMLAPIAdaptor at compile time.
+var (
+	_ AIMLAPIAdaptor = (*openAIAdaptor)(nil)
+	_ AIMLAPIAdaptor = (*anthropicAdaptor)(nil)
+	_ AIMLAPIAdaptor = (*googleAdaptor)(nil)
+	_ AIMLAPIAdaptor = (*metaLlamaAdaptor)(nil)
+	_ AIMLAPIAdaptor = (*deepSeekAdaptor)(nil)
+)
+
+var errNoChoices = fmt.Errorf("aimlapi: completion response contained no choices")
@@ -0,0 +1,195 @@
+// Copyright 2023 The Casibase Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"testing"
+
+	aimlapi "github.com/D1m7asis/casibase-aimlapi-go"
+)
+
+func TestGetAdaptor(t *testing.T) {
+	tests := []struct {
+		subType string
+		want    AIMLAPIAdaptor
+	}{
+		{"openai/gpt-4o", &openAIAdaptor{}},
+		{"", &openAIAdaptor{}},
+		{"claude-3-5-sonnet", &anthropicAdaptor{}},
+		{"google/gemini-1.5-pro", &googleAdaptor{}},
+		{"meta-llama/llama-3.1-70b", &metaLlamaAdaptor{}},
+		{"deepseek-reasoner", &deepSeekAdaptor{}},
+		{"some-unknown-vendor/model", &openAIAdaptor{}},
+	}
+
+	for _, tt := range tests {
+		got := getAdaptor(tt.subType)
+		if gotType, wantType := fmt.Sprintf("%T", got), fmt.Sprintf("%T", tt.want); gotType != wantType {
+			t.Errorf("getAdaptor(%q) = %s, want %s", tt.subType, gotType, wantType)
+		}
+	}
+}
+
+func TestBaseOpenAICompatAdaptorConvertRequest(t *testing.T) {
+	adaptor := &baseOpenAICompatAdaptor{}
+	temperature := float32(0.7)
+	topP := float32(0.9)
+
+	req := &AIMLAPIRequest{
+		Model: "openai/gpt-4o",
+		Messages: []aimlapi.ChatCompletionMessage{
+			{Role: aimlapi.ChatMessageRoleUser, Content: "hello"},
+		},
+		Temperature: &temperature,
+		TopP:        &topP,
+		MaxTokens:   512,
+		Tools: []ToolSpec{
+			{Name: "get_weather", Description: "looks up the weather", Parameters: map[string]interface{}{"type": "object"}},
+		},
+	}
+
+	vendorReq, err := adaptor.ConvertRequest(req)
+	if err != nil {
+		t.Fatalf("ConvertRequest returned error: %v", err)
+	}
+
+	if vendorReq.Model != req.Model {
+		t.Errorf("Model = %q, want %q", vendorReq.Model, req.Model)
+	}
+	if !vendorReq.Stream {
+		t.Error("Stream = false, want true")
+	}
+	if vendorReq.MaxTokens != req.MaxTokens {
+		t.Errorf("MaxTokens = %d, want %d", vendorReq.MaxTokens, req.MaxTokens)
+	}
+	if len(vendorReq.Messages) != 1 || vendorReq.Messages[0].Content != "hello" {
+		t.Errorf("Messages = %+v, want a single message with content %q", vendorReq.Messages, "hello")
+	}
+	if len(vendorReq.Tools) != 1 || vendorReq.Tools[0].Function.Name != "get_weather" {
+		t.Errorf("Tools = %+v, want a single tool named get_weather", vendorReq.Tools)
+	}
+}
+
+func TestBaseOpenAICompatAdaptorConvertRequestNoTools(t *testing.T) {
+	adaptor := &baseOpenAICompatAdaptor{}
+
+	vendorReq, err := adaptor.ConvertRequest(&AIMLAPIRequest{Model: "openai/gpt-4o"})
+	if err != nil {
+		t.Fatalf("ConvertRequest returned error: %v", err)
+	}
+	if vendorReq.Tools != nil {
+		t.Errorf("Tools = %+v, want nil when no tools are requested", vendorReq.Tools)
+	}
+}
+
+func TestBaseOpenAICompatAdaptorConvertStreamChunk(t *testing.T) {
+	adaptor := &baseOpenAICompatAdaptor{}
+
+	completion := aimlapi.ChatCompletionStreamResponse{
+		Choices: []aimlapi.ChatCompletionStreamChoice{
+			{
+				Delta:        aimlapi.ChatCompletionStreamChoiceDelta{Content: "hello"},
+				FinishReason: "",
+			},
+		},
+	}
+
+	chunk, err := adaptor.ConvertStreamChunk(completion)
+	if err != nil {
+		t.Fatalf("ConvertStreamChunk returned error: %v", err)
+	}
+	if chunk.Text != "hello" {
+		t.Errorf("Text = %q, want %q", chunk.Text, "hello")
+	}
+	if chunk.ReasoningText != "" {
+		t.Errorf("ReasoningText = %q, want empty for a non-reasoning vendor", chunk.ReasoningText)
+	}
+	if chunk.Done {
+		t.Error("Done = true, want false when FinishReason is empty")
+	}
+}
+
+func TestBaseOpenAICompatAdaptorConvertStreamChunkNoChoices(t *testing.T) {
+	adaptor := &baseOpenAICompatAdaptor{}
+
+	_, err := adaptor.ConvertStreamChunk(aimlapi.ChatCompletionStreamResponse{})
+	if err != errNoChoices {
+		t.Errorf("err = %v, want errNoChoices", err)
+	}
+}
+
+func TestDeepSeekAdaptorConvertStreamChunkSeparatesReasoning(t *testing.T) {
+	adaptor := &deepSeekAdaptor{}
+
+	completion := aimlapi.ChatCompletionStreamResponse{
+		Choices: []aimlapi.ChatCompletionStreamChoice{
+			{
+				Delta: aimlapi.ChatCompletionStreamChoiceDelta{
+					Content:          "the answer is 4",
+					ReasoningContent: "2 + 2 = 4",
+				},
+				FinishReason: "stop",
+			},
+		},
+	}
+
+	chunk, err := adaptor.ConvertStreamChunk(completion)
+	if err != nil {
+		t.Fatalf("ConvertStreamChunk returned error: %v", err)
+	}
+	if chunk.Text != "the answer is 4" {
+		t.Errorf("Text = %q, want %q", chunk.Text, "the answer is 4")
+	}
+	if chunk.ReasoningText != "2 + 2 = 4" {
+		t.Errorf("ReasoningText = %q, want %q", chunk.ReasoningText, "2 + 2 = 4")
+	}
+	if !chunk.Done {
+		t.Error("Done = false, want true when FinishReason is set")
+	}
+}
+
+func TestConvertStreamChunkToolCallDeltas(t *testing.T) {
+	adaptor := &baseOpenAICompatAdaptor{}
+
+	completion := aimlapi.ChatCompletionStreamResponse{
+		Choices: []aimlapi.ChatCompletionStreamChoice{
+			{
+				Delta: aimlapi.ChatCompletionStreamChoiceDelta{
+					ToolCalls: []aimlapi.ToolCall{
+						{
+							Index:    0,
+							Id:       "call_1",
+							Function: aimlapi.FunctionCall{Name: "get_weather", Arguments: `{"city":`},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	chunk, err := adaptor.ConvertStreamChunk(completion)
+	if err != nil {
+		t.Fatalf("ConvertStreamChunk returned error: %v", err)
+	}
+	if len(chunk.ToolCallDeltas) != 1 {
+		t.Fatalf("ToolCallDeltas = %+v, want exactly one delta", chunk.ToolCallDeltas)
+	}
+
+	delta := chunk.ToolCallDeltas[0]
+	if delta.Id != "call_1" || delta.Name != "get_weather" || delta.ArgumentsFragment != `{"city":` {
+		t.Errorf("delta = %+v, unexpected fields", delta)
+	}
+}
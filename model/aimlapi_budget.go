@@ -0,0 +1,137 @@
+// Copyright 2023 The Casibase Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrBudgetExceeded is returned when a request's estimated or running cost
+// crosses the configured per-model/per-capability or per-request cap.
+type ErrBudgetExceeded struct {
+	Model     string
+	Estimated float64
+	Cap       float64
+}
+
+func (e *ErrBudgetExceeded) Error() string {
+	return fmt.Sprintf("aimlapi: estimated cost %.6f USD for model [%s] exceeds budget cap %.6f USD", e.Estimated, e.Model, e.Cap)
+}
+
+// capabilityOf returns the coarse capability bucket a subType falls into, used
+// to look up MaxPricePerCapability when no per-model cap is configured. Vision
+// is read off the model registry's modalities rather than guessed from the
+// subType string, since vendors don't consistently spell "vision" into their
+// model ids.
+func (p *AIMLAPIModelProvider) capabilityOf(subType string) string {
+	if info, ok := p.registry.Get(subType); ok {
+		for _, modality := range info.Modalities {
+			if modality == ModalityVision {
+				return "vision"
+			}
+		}
+	}
+
+	switch {
+	case strings.Contains(subType, "reasoner"), strings.HasPrefix(subType, "o1"):
+		return "reasoning"
+	default:
+		return "chat"
+	}
+}
+
+// effectiveCap resolves the tightest applicable budget cap (in USD) for
+// subType: a per-model cap takes precedence over a per-capability one, and
+// the overall per-request ceiling always applies on top. A cap of 0 means
+// "unset" and is ignored.
+func (p *AIMLAPIModelProvider) effectiveCap(subType string) float64 {
+	priceCap := 0.0
+	if v, ok := p.maxPricePerCapability[subType]; ok && v > 0 {
+		priceCap = v
+	} else if v, ok := p.maxPricePerCapability[p.capabilityOf(subType)]; ok && v > 0 {
+		priceCap = v
+	}
+
+	if p.maxBudgetPerRequest > 0 && (priceCap == 0 || p.maxBudgetPerRequest < priceCap) {
+		priceCap = p.maxBudgetPerRequest
+	}
+	return priceCap
+}
+
+// estimateWorstCaseCost prices tokenCount prompt tokens plus maxTokens
+// completion tokens at the model's rate, giving an upper bound on what the
+// request could cost before any upstream call is made.
+func (p *AIMLAPIModelProvider) estimateWorstCaseCost(subType string, tokenCount int, maxTokens int) (float64, error) {
+	priceEntry, err := p.pricingSource.GetPrice(subType)
+	if err != nil {
+		return 0, err
+	}
+
+	inputPrice := new(big.Rat).Mul(big.NewRat(int64(tokenCount), 1000), priceEntry.InputPerThousand)
+	outputPrice := new(big.Rat).Mul(big.NewRat(int64(maxTokens), 1000), priceEntry.OutputPerThousand)
+	total, _ := new(big.Rat).Add(inputPrice, outputPrice).Float64()
+	return total, nil
+}
+
+// runningCost prices the prompt tokens plus the tokens generated so far,
+// used to check the budget mid-stream.
+func (p *AIMLAPIModelProvider) runningCost(subType string, tokenCount int, responseSoFar string) (float64, error) {
+	priceEntry, err := p.pricingSource.GetPrice(subType)
+	if err != nil {
+		return 0, err
+	}
+
+	responseTokenCount, err := GetTokenSize(subType, responseSoFar)
+	if err != nil {
+		return 0, err
+	}
+
+	inputPrice := new(big.Rat).Mul(big.NewRat(int64(tokenCount), 1000), priceEntry.InputPerThousand)
+	outputPrice := new(big.Rat).Mul(big.NewRat(int64(responseTokenCount), 1000), priceEntry.OutputPerThousand)
+	total, _ := new(big.Rat).Add(inputPrice, outputPrice).Float64()
+	return total, nil
+}
+
+// loadBudgetFromEnv seeds MaxPricePerCapability and the per-request ceiling
+// from the environment so deployments can tune spend without a code change:
+//
+//	AIMLAPI_MAX_BUDGET_PER_REQUEST=0.50
+//	AIMLAPI_MAX_PRICE_CHAT=0.02
+//	AIMLAPI_MAX_PRICE_VISION=0.05
+//	AIMLAPI_MAX_PRICE_REASONING=0.10
+func loadBudgetFromEnv() (map[string]float64, float64) {
+	perCapability := map[string]float64{}
+	for _, capability := range []string{"chat", "vision", "reasoning"} {
+		key := "AIMLAPI_MAX_PRICE_" + strings.ToUpper(capability)
+		if v, ok := os.LookupEnv(key); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				perCapability[capability] = parsed
+			}
+		}
+	}
+
+	perRequest := 0.0
+	if v, ok := os.LookupEnv("AIMLAPI_MAX_BUDGET_PER_REQUEST"); ok {
+		if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+			perRequest = parsed
+		}
+	}
+
+	return perCapability, perRequest
+}
@@ -0,0 +1,283 @@
+// Copyright 2023 The Casibase Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	aimlapi "github.com/D1m7asis/casibase-aimlapi-go"
+)
+
+// defaultSystemPrompt is used when the caller supplies no prompt.
+const defaultSystemPrompt = "You are a helpful assistant."
+
+// defaultSummarizerModel is the cheap model TrimSummarizeOldest falls back
+// to when the provider has none configured.
+const defaultSummarizerModel = "gpt-4o-mini"
+
+// defaultCompletionTokenReserve is the headroom reserved for the model's
+// answer when deciding how many prompt tokens (system + knowledge + history
+// + question) are allowed to fit in the model's context window.
+const defaultCompletionTokenReserve = 1024
+
+// defaultSummaryTokenReserve bounds how many tokens TrimSummarizeOldest's
+// summary note may add. It is reserved out of the history budget up front
+// (rather than discovered after the fact) and passed as MaxTokens on the
+// secondary summarizer call, so the summary itself can never be the thing
+// that pushes the final prompt over the model's context window.
+const defaultSummaryTokenReserve = 256
+
+// TrimStrategy picks how buildMessages makes room when history plus
+// knowledge plus the new question would not fit the model's context.
+type TrimStrategy string
+
+const (
+	// TrimHeadDrop discards the oldest history messages, one at a time, until
+	// the rest fits. It packs the budget as tightly as possible, which can
+	// leave a dangling assistant reply with the user question that prompted
+	// it dropped (or vice versa).
+	TrimHeadDrop TrimStrategy = "head-drop"
+	// TrimTailKeep keeps the newest history turns that fit, where a turn is a
+	// (user, assistant) message pair: it never splits one, so it may drop one
+	// extra message compared to TrimHeadDrop's tighter packing in exchange
+	// for never keeping a reply without the question that prompted it.
+	TrimTailKeep TrimStrategy = "tail-keep"
+	// TrimSummarizeOldest compresses the turns that would otherwise be
+	// dropped into a single system note via a secondary cheap-model call.
+	TrimSummarizeOldest TrimStrategy = "summarize-oldest"
+)
+
+// trimResult is the message array QueryText sends upstream, plus the
+// bookkeeping calculatePrice and logging need.
+type trimResult struct {
+	Messages         []aimlapi.ChatCompletionMessage
+	PromptTokenCount int
+	TrimmedCount     int
+}
+
+// buildMessages assembles the full message array QueryText sends upstream:
+// a system message (prompt, falling back to defaultSystemPrompt), the
+// knowledge messages, the conversation history, and finally the new
+// question. If that does not fit in contextLength minus the headroom
+// reserved for the completion, it trims history turns - oldest first -
+// according to p.trimStrategy until it does.
+func (p *AIMLAPIModelProvider) buildMessages(subType string, prompt string, knowledgeMessages []*RawMessage, history []*RawMessage, question string, contextLength int) (*trimResult, error) {
+	systemContent := prompt
+	if systemContent == "" {
+		systemContent = defaultSystemPrompt
+	}
+	system := aimlapi.ChatCompletionMessage{Role: aimlapi.ChatMessageRoleSystem, Content: systemContent}
+	knowledge := toChatMessages(knowledgeMessages)
+	historyMessages := toChatMessages(history)
+	userQuestion := aimlapi.ChatCompletionMessage{Role: aimlapi.ChatMessageRoleUser, Content: question}
+
+	fixed := append([]aimlapi.ChatCompletionMessage{system}, knowledge...)
+	fixed = append(fixed, userQuestion)
+	fixedTokens, err := sumTokenSize(subType, fixed)
+	if err != nil {
+		return nil, err
+	}
+
+	budget := contextLength - defaultCompletionTokenReserve
+	remaining := budget - fixedTokens
+	if p.trimStrategy == TrimSummarizeOldest {
+		// Reserve room for the summary note up front, since it is appended
+		// after kept is chosen and must not be what pushes the prompt over
+		// contextLength.
+		remaining -= defaultSummaryTokenReserve
+	}
+
+	var kept []aimlapi.ChatCompletionMessage
+	if p.trimStrategy == TrimTailKeep {
+		kept, err = keptByTurn(subType, historyMessages, remaining)
+	} else {
+		kept, err = keptByMessage(subType, historyMessages, remaining)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	droppedCount := len(historyMessages) - len(kept)
+	dropped := historyMessages[:droppedCount]
+
+	messages := append([]aimlapi.ChatCompletionMessage{}, system)
+	messages = append(messages, knowledge...)
+
+	if droppedCount > 0 {
+		if p.trimStrategy == TrimSummarizeOldest {
+			summary, err := p.summarizeTurns(dropped)
+			if err != nil {
+				return nil, err
+			}
+			if summary != "" {
+				messages = append(messages, aimlapi.ChatCompletionMessage{
+					Role:    aimlapi.ChatMessageRoleSystem,
+					Content: "Summary of earlier conversation: " + summary,
+				})
+			}
+		}
+		log.Printf("aimlapi: trimmed %d of %d history message(s) for model [%s] using %q strategy", droppedCount, len(historyMessages), subType, p.trimStrategy)
+	}
+
+	messages = append(messages, kept...)
+	messages = append(messages, userQuestion)
+
+	promptTokenCount, err := sumTokenSize(subType, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	return &trimResult{Messages: messages, PromptTokenCount: promptTokenCount, TrimmedCount: droppedCount}, nil
+}
+
+// summarizeTurns compresses dropped history turns into a single note via a
+// non-streaming call to the provider's configured summarizer model.
+func (p *AIMLAPIModelProvider) summarizeTurns(turns []aimlapi.ChatCompletionMessage) (string, error) {
+	if len(turns) == 0 {
+		return "", nil
+	}
+
+	model := p.summarizerModel
+	if model == "" {
+		model = defaultSummarizerModel
+	}
+
+	transcript := strings.Builder{}
+	for _, turn := range turns {
+		fmt.Fprintf(&transcript, "%s: %s\n", turn.Role, turn.Content)
+	}
+
+	resp, err := p.getClient().CreateChatCompletion(context.Background(), &aimlapi.ChatCompletionRequest{
+		Model:     model,
+		MaxTokens: defaultSummaryTokenReserve,
+		Messages: []aimlapi.ChatCompletionMessage{
+			{Role: aimlapi.ChatMessageRoleSystem, Content: "Summarize the following conversation turns into a single concise note that preserves the facts needed to continue the conversation."},
+			{Role: aimlapi.ChatMessageRoleUser, Content: transcript.String()},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", nil
+	}
+
+	return resp.Choices[0].Message.Content, nil
+}
+
+// toChatMessages converts Casibase's generic RawMessage history/knowledge
+// entries into the vendor SDK's message shape. An AI-authored message
+// becomes an assistant turn; everything else is a user turn.
+func toChatMessages(raws []*RawMessage) []aimlapi.ChatCompletionMessage {
+	if len(raws) == 0 {
+		return nil
+	}
+
+	messages := make([]aimlapi.ChatCompletionMessage, 0, len(raws))
+	for _, raw := range raws {
+		role := aimlapi.ChatMessageRoleUser
+		if raw.Author == "AI" {
+			role = aimlapi.ChatMessageRoleAssistant
+		}
+		messages = append(messages, aimlapi.ChatCompletionMessage{Role: role, Content: raw.Text})
+	}
+	return messages
+}
+
+// sumTokenSize adds up GetTokenSize across every message's content.
+func sumTokenSize(subType string, messages []aimlapi.ChatCompletionMessage) (int, error) {
+	total := 0
+	for _, message := range messages {
+		size, err := GetTokenSize(subType, message.Content)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// keptByMessage is TrimHeadDrop's selection: walk history newest-first,
+// keeping individual messages while they fit in remaining. This packs the
+// budget as tightly as a single message allows.
+func keptByMessage(subType string, historyMessages []aimlapi.ChatCompletionMessage, remaining int) ([]aimlapi.ChatCompletionMessage, error) {
+	kept := make([]aimlapi.ChatCompletionMessage, 0, len(historyMessages))
+	keptTokens := 0
+	for i := len(historyMessages) - 1; i >= 0; i-- {
+		size, err := GetTokenSize(subType, historyMessages[i].Content)
+		if err != nil {
+			return nil, err
+		}
+		if keptTokens+size > remaining {
+			break
+		}
+		kept = append(kept, historyMessages[i])
+		keptTokens += size
+	}
+	for l, r := 0, len(kept)-1; l < r; l, r = l+1, r-1 {
+		kept[l], kept[r] = kept[r], kept[l]
+	}
+	return kept, nil
+}
+
+// keptByTurn is TrimTailKeep's selection: group history into (user,
+// assistant) turns and keep whole turns newest-first, so a kept assistant
+// reply never loses the user question that prompted it.
+func keptByTurn(subType string, historyMessages []aimlapi.ChatCompletionMessage, remaining int) ([]aimlapi.ChatCompletionMessage, error) {
+	turns := groupTurns(historyMessages)
+
+	keptTurns := make([][]aimlapi.ChatCompletionMessage, 0, len(turns))
+	keptTokens := 0
+	for i := len(turns) - 1; i >= 0; i-- {
+		size, err := sumTokenSize(subType, turns[i])
+		if err != nil {
+			return nil, err
+		}
+		if keptTokens+size > remaining {
+			break
+		}
+		keptTurns = append(keptTurns, turns[i])
+		keptTokens += size
+	}
+	for l, r := 0, len(keptTurns)-1; l < r; l, r = l+1, r-1 {
+		keptTurns[l], keptTurns[r] = keptTurns[r], keptTurns[l]
+	}
+
+	kept := make([]aimlapi.ChatCompletionMessage, 0, len(historyMessages))
+	for _, turn := range keptTurns {
+		kept = append(kept, turn...)
+	}
+	return kept, nil
+}
+
+// groupTurns pairs up consecutive (user, assistant) messages into turns. A
+// user message with no following assistant reply (e.g. the history ends
+// mid-turn) becomes a single-message turn of its own.
+func groupTurns(messages []aimlapi.ChatCompletionMessage) [][]aimlapi.ChatCompletionMessage {
+	turns := make([][]aimlapi.ChatCompletionMessage, 0, len(messages))
+	for i := 0; i < len(messages); {
+		if i+1 < len(messages) && messages[i].Role == aimlapi.ChatMessageRoleUser && messages[i+1].Role == aimlapi.ChatMessageRoleAssistant {
+			turns = append(turns, messages[i:i+2])
+			i += 2
+			continue
+		}
+		turns = append(turns, messages[i:i+1])
+		i++
+	}
+	return turns
+}
@@ -0,0 +1,285 @@
+// Copyright 2023 The Casibase Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrUnknownModelPricing is returned instead of silently billing 0 when a
+// model has no known price entry in any configured PricingSource.
+type ErrUnknownModelPricing struct {
+	Model string
+}
+
+func (e *ErrUnknownModelPricing) Error() string {
+	return fmt.Sprintf("aimlapi: no pricing information for model [%s]", e.Model)
+}
+
+// AIMLAPIPriceEntry holds the per-thousand-token input/output price of a
+// model in USD, using big.Rat so accumulated costs don't drift under
+// high-volume billing.
+type AIMLAPIPriceEntry struct {
+	InputPerThousand  *big.Rat
+	OutputPerThousand *big.Rat
+}
+
+// PricingSource resolves a model's price entry. The default implementation
+// (staticPricingSource) reads the provider's ModelRegistry; WithFilePricingSource
+// and WithRemotePricingSource layer a file- or HTTPS-backed source on top of
+// it (see chainedPricingSource), and callers may supply their own.
+type PricingSource interface {
+	GetPrice(model string) (*AIMLAPIPriceEntry, error)
+}
+
+// staticPricingSource serves the owning provider's model registry - the
+// embedded manifest plus any WithModelRegistryOverrides. It is the last
+// source consulted when file or remote sources are layered on top of it.
+type staticPricingSource struct {
+	registry *ModelRegistry
+}
+
+func (s *staticPricingSource) GetPrice(model string) (*AIMLAPIPriceEntry, error) {
+	registry := s.registry
+	if registry == nil {
+		registry = defaultModelRegistry
+	}
+
+	info, ok := registry.Get(model)
+	if !ok {
+		return nil, &ErrUnknownModelPricing{Model: model}
+	}
+	return &AIMLAPIPriceEntry{
+		InputPerThousand:  new(big.Rat).SetFloat64(info.InputPricePerThousand),
+		OutputPerThousand: new(big.Rat).SetFloat64(info.OutputPricePerThousand),
+	}, nil
+}
+
+// filePricingSource loads a JSON price table of the form
+// {"openai/gpt-4o": [0.005, 0.015], ...} from disk.
+type filePricingSource struct {
+	path string
+}
+
+func newFilePricingSource(path string) *filePricingSource {
+	return &filePricingSource{path: path}
+}
+
+func (s *filePricingSource) load() (map[string][]float64, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	table := map[string][]float64{}
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+func (s *filePricingSource) GetPrice(model string) (*AIMLAPIPriceEntry, error) {
+	table, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	priceItem, ok := table[model]
+	if !ok || len(priceItem) != 2 {
+		return nil, &ErrUnknownModelPricing{Model: model}
+	}
+	return &AIMLAPIPriceEntry{
+		InputPerThousand:  new(big.Rat).SetFloat64(priceItem[0]),
+		OutputPerThousand: new(big.Rat).SetFloat64(priceItem[1]),
+	}, nil
+}
+
+// remotePricingSource periodically refreshes a JSON price table from an
+// HTTPS endpoint, using ETag/If-Modified-Since to avoid re-downloading
+// unchanged tables. The last good snapshot is served if a refresh fails.
+type remotePricingSource struct {
+	url        string
+	httpClient *http.Client
+	ttl        time.Duration
+
+	mu        sync.RWMutex
+	table     map[string][]float64
+	etag      string
+	lastMod   string
+	fetchedAt time.Time
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+func newRemotePricingSource(url string, httpClient *http.Client, ttl time.Duration) *remotePricingSource {
+	s := &remotePricingSource{
+		url:        url,
+		httpClient: httpClient,
+		ttl:        ttl,
+		stopCh:     make(chan struct{}),
+	}
+	return s
+}
+
+// Start launches the background refresher. It refreshes once synchronously
+// so the first GetPrice call after construction has data, then continues on
+// ttl+jitter in a goroutine until Stop is called.
+func (s *remotePricingSource) Start() {
+	_ = s.refresh()
+	go s.loop()
+}
+
+func (s *remotePricingSource) Stop() {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+}
+
+func (s *remotePricingSource) loop() {
+	for {
+		jitter := time.Duration(rand.Int63n(int64(s.ttl) / 4))
+		select {
+		case <-time.After(s.ttl + jitter):
+			_ = s.refresh()
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+func (s *remotePricingSource) refresh() error {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	etag, lastMod := s.etag, s.lastMod
+	s.mu.RUnlock()
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastMod != "" {
+		req.Header.Set("If-Modified-Since", lastMod)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		// Keep serving the last good snapshot on fetch failure.
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		s.mu.Lock()
+		s.fetchedAt = time.Now()
+		s.mu.Unlock()
+		return nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("aimlapi: pricing endpoint returned status %d", resp.StatusCode)
+	}
+
+	table := map[string][]float64{}
+	if err := json.NewDecoder(resp.Body).Decode(&table); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.table = table
+	s.etag = resp.Header.Get("ETag")
+	s.lastMod = resp.Header.Get("Last-Modified")
+	s.fetchedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *remotePricingSource) GetPrice(model string) (*AIMLAPIPriceEntry, error) {
+	s.mu.RLock()
+	priceItem, ok := s.table[model]
+	s.mu.RUnlock()
+
+	if !ok || len(priceItem) != 2 {
+		return nil, &ErrUnknownModelPricing{Model: model}
+	}
+	return &AIMLAPIPriceEntry{
+		InputPerThousand:  new(big.Rat).SetFloat64(priceItem[0]),
+		OutputPerThousand: new(big.Rat).SetFloat64(priceItem[1]),
+	}, nil
+}
+
+// chainedPricingSource tries each source in order and returns the first hit,
+// falling through to the static table last.
+type chainedPricingSource struct {
+	sources []PricingSource
+}
+
+func (s *chainedPricingSource) GetPrice(model string) (*AIMLAPIPriceEntry, error) {
+	var lastErr error
+	for _, source := range s.sources {
+		entry, err := source.GetPrice(model)
+		if err == nil {
+			return entry, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = &ErrUnknownModelPricing{Model: model}
+	}
+	return nil, lastErr
+}
+
+// CurrencyConverter converts a USD-denominated big.Rat amount into another
+// currency using user-configurable exchange rates.
+type CurrencyConverter struct {
+	mu    sync.RWMutex
+	rates map[string]*big.Rat // currency code -> units per 1 USD
+}
+
+// NewCurrencyConverter builds a converter seeded with the given rates. USD
+// itself is always available at a rate of 1.
+func NewCurrencyConverter(ratesPerUSD map[string]float64) *CurrencyConverter {
+	c := &CurrencyConverter{rates: map[string]*big.Rat{"USD": big.NewRat(1, 1)}}
+	for currency, rate := range ratesPerUSD {
+		c.SetRate(currency, rate)
+	}
+	return c
+}
+
+func (c *CurrencyConverter) SetRate(currency string, ratePerUSD float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rates[currency] = new(big.Rat).SetFloat64(ratePerUSD)
+}
+
+// Convert returns amountUSD expressed in currency. It returns an error if
+// currency has no configured rate.
+func (c *CurrencyConverter) Convert(amountUSD *big.Rat, currency string) (*big.Rat, error) {
+	c.mu.RLock()
+	rate, ok := c.rates[currency]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("aimlapi: no exchange rate configured for currency [%s]", currency)
+	}
+	return new(big.Rat).Mul(amountUSD, rate), nil
+}
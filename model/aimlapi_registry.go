@@ -0,0 +1,206 @@
+// Copyright 2023 The Casibase Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+//go:generate go run ./internal/validatemanifest aimlapi_models.json aimlapi_models.schema.json
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Modality is one input/output mode a model accepts, e.g. "text", "vision",
+// "audio".
+type Modality string
+
+const (
+	ModalityText   Modality = "text"
+	ModalityVision Modality = "vision"
+	ModalityAudio  Modality = "audio"
+)
+
+// defaultContextLength is used for a subType with no registry entry at all,
+// so an unknown model degrades to a conservative budget instead of panicking.
+const defaultContextLength = 8192
+
+// ModelInfo describes one AIMLAPI subType: who serves it, what it accepts,
+// what it costs, and what its safe defaults are. It is what ListModels and
+// GetModelInfo hand back to upstream Casibase code for model pickers and
+// request validation.
+type ModelInfo struct {
+	SubType                string
+	Vendor                 string
+	ContextLength          int
+	Modalities             []Modality
+	SupportsTools          bool
+	SupportsStreaming      bool
+	InputPricePerThousand  float64
+	OutputPricePerThousand float64
+	DefaultTemperature     float32
+	DefaultTopP            float32
+}
+
+// modelManifestEntry is the on-disk JSON shape of one ModelInfo, validated
+// against aimlapi_models.schema.json by `go generate` (internal/validatemanifest).
+type modelManifestEntry struct {
+	SubType                string   `json:"sub_type"`
+	Vendor                 string   `json:"vendor"`
+	ContextLength          int      `json:"context_length"`
+	Modalities             []string `json:"modalities"`
+	SupportsTools          bool     `json:"supports_tools"`
+	SupportsStreaming      bool     `json:"supports_streaming"`
+	InputPricePerThousand  float64  `json:"input_price_per_thousand"`
+	OutputPricePerThousand float64  `json:"output_price_per_thousand"`
+	DefaultTemperature     float32  `json:"default_temperature"`
+	DefaultTopP            float32  `json:"default_top_p"`
+}
+
+func (e *modelManifestEntry) toModelInfo() ModelInfo {
+	modalities := make([]Modality, 0, len(e.Modalities))
+	for _, m := range e.Modalities {
+		modalities = append(modalities, Modality(m))
+	}
+
+	return ModelInfo{
+		SubType:                e.SubType,
+		Vendor:                 e.Vendor,
+		ContextLength:          e.ContextLength,
+		Modalities:             modalities,
+		SupportsTools:          e.SupportsTools,
+		SupportsStreaming:      e.SupportsStreaming,
+		InputPricePerThousand:  e.InputPricePerThousand,
+		OutputPricePerThousand: e.OutputPricePerThousand,
+		DefaultTemperature:     e.DefaultTemperature,
+		DefaultTopP:            e.DefaultTopP,
+	}
+}
+
+//go:embed aimlapi_models.json
+var embeddedModelManifest []byte
+
+// ModelRegistry catalogs every AIMLAPI subType Casibase knows about. It is
+// seeded from the embedded manifest and may be layered with user overrides
+// (see LoadOverrides) without touching the compiled-in defaults.
+type ModelRegistry struct {
+	mu     sync.RWMutex
+	models map[string]ModelInfo
+}
+
+func newModelRegistry() *ModelRegistry {
+	r := &ModelRegistry{models: map[string]ModelInfo{}}
+	if err := r.loadManifest(embeddedModelManifest); err != nil {
+		// The embedded manifest ships with the binary; a decode failure here
+		// is a packaging bug, not a runtime condition callers can recover from.
+		panic(fmt.Sprintf("aimlapi: embedded model manifest is invalid: %v", err))
+	}
+	return r
+}
+
+func (r *ModelRegistry) loadManifest(data []byte) error {
+	var entries []modelManifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, entry := range entries {
+		r.models[entry.SubType] = entry.toModelInfo()
+	}
+	return nil
+}
+
+// LoadOverrides layers the manifest at path on top of the current registry:
+// entries with a subType already known are replaced, new ones are added. It
+// is meant for deployment-specific models or price corrections without
+// recompiling the embedded manifest.
+func (r *ModelRegistry) LoadOverrides(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return r.loadManifest(data)
+}
+
+// Get returns the ModelInfo for subType, if the registry knows about it.
+func (r *ModelRegistry) Get(subType string) (ModelInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	info, ok := r.models[subType]
+	return info, ok
+}
+
+// List returns every registered ModelInfo, sorted by subType for stable
+// rendering in model pickers.
+func (r *ModelRegistry) List() []ModelInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	infos := make([]ModelInfo, 0, len(r.models))
+	for _, info := range r.models {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].SubType < infos[j].SubType })
+	return infos
+}
+
+// ContextLength returns subType's registered context length, or
+// defaultContextLength if subType is unknown.
+func (r *ModelRegistry) ContextLength(subType string) int {
+	if info, ok := r.Get(subType); ok {
+		return info.ContextLength
+	}
+	return defaultContextLength
+}
+
+// defaultModelRegistry is the process-wide registry seeded from the embedded
+// manifest. Every AIMLAPIModelProvider starts out pointing at it; call
+// WithModelRegistryOverrides to layer deployment-specific entries onto a
+// provider's own copy instead of mutating the shared one.
+var defaultModelRegistry = newModelRegistry()
+
+// WithModelRegistryOverrides gives the provider its own ModelRegistry, seeded
+// from the embedded manifest plus the overrides at path, so one deployment's
+// custom pricing or model list doesn't leak into another's.
+func WithModelRegistryOverrides(path string) AIMLAPIOption {
+	return func(p *AIMLAPIModelProvider) {
+		registry := newModelRegistry()
+		if err := registry.LoadOverrides(path); err != nil {
+			// Keep serving the embedded defaults if the override file is bad.
+			return
+		}
+		p.registry = registry
+	}
+}
+
+// ListModels exposes the provider's model catalog so upstream Casibase code
+// can render a model picker.
+func (p *AIMLAPIModelProvider) ListModels() []ModelInfo {
+	return p.registry.List()
+}
+
+// GetModelInfo looks up a single subType, e.g. to validate a request before
+// issuing it.
+func (p *AIMLAPIModelProvider) GetModelInfo(subType string) (ModelInfo, error) {
+	info, ok := p.registry.Get(subType)
+	if !ok {
+		return ModelInfo{}, fmt.Errorf("aimlapi: no registry entry for model [%s]", subType)
+	}
+	return info, nil
+}
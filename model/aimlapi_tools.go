@@ -0,0 +1,130 @@
+// Copyright 2023 The Casibase Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"sort"
+
+	aimlapi "github.com/D1m7asis/casibase-aimlapi-go"
+)
+
+// ToolSpec describes one function an agent may call, in the OpenAI function-
+// calling shape that every AIMLAPI vendor adaptor speaks.
+type ToolSpec struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{}
+}
+
+// ToolCallDelta is one streamed fragment of a tool call; Id and Name are
+// empty on chunks that only continue a prior fragment's JSON arguments.
+type ToolCallDelta struct {
+	Index             int
+	Id                string
+	Name              string
+	ArgumentsFragment string
+}
+
+// ToolCall is a fully assembled tool call, ready for an agent to execute.
+type ToolCall struct {
+	Id        string
+	Name      string
+	Arguments string
+}
+
+// toVendorTools converts generic ToolSpecs into the vendor SDK's tool shape.
+// It returns nil (rather than an empty slice) when there are no tools so
+// requests without tool support enabled are unaffected.
+func toVendorTools(tools []ToolSpec) []aimlapi.Tool {
+	if len(tools) == 0 {
+		return nil
+	}
+
+	vendorTools := make([]aimlapi.Tool, 0, len(tools))
+	for _, tool := range tools {
+		vendorTools = append(vendorTools, aimlapi.Tool{
+			Type: "function",
+			Function: aimlapi.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	return vendorTools
+}
+
+func toToolCallDeltas(vendorToolCalls []aimlapi.ToolCall) []ToolCallDelta {
+	if len(vendorToolCalls) == 0 {
+		return nil
+	}
+
+	deltas := make([]ToolCallDelta, 0, len(vendorToolCalls))
+	for _, vendorToolCall := range vendorToolCalls {
+		deltas = append(deltas, ToolCallDelta{
+			Index:             vendorToolCall.Index,
+			Id:                vendorToolCall.Id,
+			Name:              vendorToolCall.Function.Name,
+			ArgumentsFragment: vendorToolCall.Function.Arguments,
+		})
+	}
+	return deltas
+}
+
+// toolCallAccumulator reassembles per-index tool-call fragments streamed
+// across many chunks into complete ToolCalls.
+type toolCallAccumulator struct {
+	byIndex map[int]*ToolCall
+}
+
+func newToolCallAccumulator() *toolCallAccumulator {
+	return &toolCallAccumulator{byIndex: map[int]*ToolCall{}}
+}
+
+func (a *toolCallAccumulator) add(deltas []ToolCallDelta) {
+	for _, delta := range deltas {
+		call, ok := a.byIndex[delta.Index]
+		if !ok {
+			call = &ToolCall{}
+			a.byIndex[delta.Index] = call
+		}
+		if delta.Id != "" {
+			call.Id = delta.Id
+		}
+		if delta.Name != "" {
+			call.Name = delta.Name
+		}
+		call.Arguments += delta.ArgumentsFragment
+	}
+}
+
+// collect returns the accumulated tool calls ordered by stream index.
+func (a *toolCallAccumulator) collect() []ToolCall {
+	if len(a.byIndex) == 0 {
+		return nil
+	}
+
+	indexes := make([]int, 0, len(a.byIndex))
+	for index := range a.byIndex {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	calls := make([]ToolCall, 0, len(indexes))
+	for _, index := range indexes {
+		calls = append(calls, *a.byIndex[index])
+	}
+	return calls
+}
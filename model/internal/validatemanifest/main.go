@@ -0,0 +1,153 @@
+// Copyright 2023 The Casibase Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command validatemanifest checks an AIMLAPI model manifest against its
+// JSON schema's required fields, their types and their enum values, and
+// rejects empty arrays. The schema's minimum and minLength bounds are not
+// checked, and "integer" fields accept any number. It is invoked by
+// `go generate` (see the directive in ../../aimlapi_registry.go) so a bad
+// manifest fails the build instead of surfacing as a panic at process start.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type schema struct {
+	Items struct {
+		Required   []string `json:"required"`
+		Properties map[string]struct {
+			Type  string   `json:"type"`
+			Enum  []string `json:"enum"`
+			Items struct {
+				Enum []string `json:"enum"`
+			} `json:"items"`
+		} `json:"properties"`
+	} `json:"items"`
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: validatemanifest <manifest.json> <schema.json>")
+		os.Exit(2)
+	}
+
+	if err := run(os.Args[1], os.Args[2]); err != nil {
+		fmt.Fprintf(os.Stderr, "validatemanifest: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(manifestPath, schemaPath string) error {
+	schemaData, err := os.ReadFile(schemaPath)
+	if err != nil {
+		return err
+	}
+	var s schema
+	if err := json.Unmarshal(schemaData, &s); err != nil {
+		return fmt.Errorf("parsing schema: %w", err)
+	}
+
+	manifestData, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+	var entries []map[string]interface{}
+	if err := json.Unmarshal(manifestData, &entries); err != nil {
+		return fmt.Errorf("parsing manifest: %w", err)
+	}
+
+	for i, entry := range entries {
+		subType, _ := entry["sub_type"].(string)
+		label := fmt.Sprintf("entry %d", i)
+		if subType != "" {
+			label = fmt.Sprintf("entry %d (%s)", i, subType)
+		}
+
+		for _, field := range s.Items.Required {
+			value, ok := entry[field]
+			if !ok {
+				return fmt.Errorf("%s: missing required field %q", label, field)
+			}
+
+			prop := s.Items.Properties[field]
+			if err := checkType(value, prop.Type); err != nil {
+				return fmt.Errorf("%s: field %q: %w", label, field, err)
+			}
+
+			enum := prop.Enum
+			if prop.Type == "array" {
+				enum = prop.Items.Enum
+			}
+			if len(enum) > 0 {
+				if err := checkEnum(value, enum); err != nil {
+					return fmt.Errorf("%s: field %q: %w", label, field, err)
+				}
+			}
+		}
+	}
+
+	fmt.Printf("validatemanifest: %d entr(ies) OK against %s\n", len(entries), schemaPath)
+	return nil
+}
+
+func checkType(value interface{}, wantType string) error {
+	switch wantType {
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("expected a string, got %T", value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("expected a boolean, got %T", value)
+		}
+	case "integer", "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("expected a number, got %T", value)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected an array, got %T", value)
+		}
+		if len(items) == 0 {
+			return fmt.Errorf("expected a non-empty array")
+		}
+	}
+	return nil
+}
+
+func checkEnum(value interface{}, allowed []string) error {
+	items, ok := value.([]interface{})
+	if !ok {
+		items = []interface{}{value}
+	}
+
+	for _, item := range items {
+		s, _ := item.(string)
+		matched := false
+		for _, a := range allowed {
+			if s == a {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("value %q is not one of %v", s, allowed)
+		}
+	}
+	return nil
+}